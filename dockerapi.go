@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// apiVersion is the Docker Engine API version dockrun speaks. It's pinned
+// rather than negotiated so the request/response shapes below stay fixed.
+const apiVersion = "v1.41"
+
+// apiRuntime drives containers directly against the Docker Engine API over
+// HTTP, instead of shelling out to the docker CLI. It understands
+// DOCKER_HOST, DOCKER_TLS_VERIFY and DOCKER_CERT_PATH the same way the CLI
+// does, so it's a drop-in replacement for whatever `docker` is already
+// configured to talk to.
+type apiRuntime struct {
+	client  *http.Client
+	baseURL string
+}
+
+// newAPIRuntime builds an apiRuntime from the standard Docker client
+// environment variables.
+func newAPIRuntime() (*apiRuntime, error) {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = "unix:///var/run/docker.sock"
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("docker: invalid DOCKER_HOST %q: %v", host, err)
+	}
+
+	transport := &http.Transport{}
+	scheme := "http"
+	addr := u.Host
+
+	switch u.Scheme {
+	case "unix":
+		path := u.Path
+		transport.DialContext = func(_ context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", path)
+		}
+		addr = "docker"
+	case "tcp", "":
+		if addr == "" {
+			addr = host
+		}
+		if os.Getenv("DOCKER_TLS_VERIFY") != "" {
+			tlsConfig, err := tlsConfigFromEnv()
+			if err != nil {
+				return nil, err
+			}
+			transport.TLSClientConfig = tlsConfig
+			scheme = "https"
+		}
+	default:
+		return nil, fmt.Errorf("docker: unsupported DOCKER_HOST scheme %q", u.Scheme)
+	}
+
+	return &apiRuntime{
+		client:  &http.Client{Transport: transport},
+		baseURL: scheme + "://" + addr + "/" + apiVersion,
+	}, nil
+}
+
+func tlsConfigFromEnv() (*tls.Config, error) {
+	certPath := os.Getenv("DOCKER_CERT_PATH")
+	if certPath == "" {
+		return nil, fmt.Errorf("docker: DOCKER_TLS_VERIFY is set but DOCKER_CERT_PATH is empty")
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certPath, "cert.pem"), filepath.Join(certPath, "key.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("docker: loading client certificate: %v", err)
+	}
+	caCert, err := ioutil.ReadFile(filepath.Join(certPath, "ca.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("docker: loading CA certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caCert)
+	return &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool}, nil
+}
+
+func (a *apiRuntime) do(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, a.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("docker: %s %s: %v", method, path, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("docker: %s %s: %v", method, path, err)
+	}
+	return resp, nil
+}
+
+// decodeOrAPIError decodes a successful JSON response into v (if non-nil),
+// or turns a >=400 response into an error using the API's
+// {"message": "..."} error body.
+func decodeOrAPIError(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if apiErr.Message != "" {
+			return fmt.Errorf("%s: %s", resp.Status, apiErr.Message)
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (a *apiRuntime) Run(args []string) (string, error) {
+	cfg, name, err := parseRunArgs(args)
+	if err != nil {
+		return "", fmt.Errorf("docker: %v", err)
+	}
+	body, err := json.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("docker: encoding create request: %v", err)
+	}
+
+	createPath := "/containers/create"
+	if name != "" {
+		createPath += "?name=" + url.QueryEscape(name)
+	}
+	resp, err := a.do("POST", createPath, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := decodeOrAPIError(resp, &created); err != nil {
+		return "", fmt.Errorf("docker: create: %v", err)
+	}
+
+	if err := a.Start(created.ID); err != nil {
+		if rmErr := a.Rm(created.ID); rmErr != nil {
+			return "", fmt.Errorf("docker: start: %v (cleanup of %s also failed: %v)", err, created.ID, rmErr)
+		}
+		return "", fmt.Errorf("docker: start: %v", err)
+	}
+	return created.ID, nil
+}
+
+func (a *apiRuntime) Wait(id string) (int, error) {
+	resp, err := a.do("POST", "/containers/"+id+"/wait", nil)
+	if err != nil {
+		return 0, err
+	}
+	var result struct {
+		StatusCode int `json:"StatusCode"`
+		Error      *struct {
+			Message string `json:"Message"`
+		} `json:"Error"`
+	}
+	if err := decodeOrAPIError(resp, &result); err != nil {
+		return 0, fmt.Errorf("docker: wait: %v", err)
+	}
+	if result.Error != nil && result.Error.Message != "" {
+		return result.StatusCode, fmt.Errorf("docker: wait: %s", result.Error.Message)
+	}
+	return result.StatusCode, nil
+}
+
+func (a *apiRuntime) Logs(id string) (string, error) {
+	resp, err := a.do("GET", "/containers/"+id+"/logs?stdout=1&stderr=1", nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("docker: logs: %v", decodeOrAPIError(resp, nil))
+	}
+	defer resp.Body.Close()
+	var out, errOut bytes.Buffer
+	if err := demuxDockerStream(resp.Body, &out, &errOut); err != nil {
+		return "", fmt.Errorf("docker: logs: %v", err)
+	}
+	return out.String() + errOut.String(), nil
+}
+
+// StreamLogs follows the container's log stream via
+// /logs?follow=1&stdout=1&stderr=1, demultiplexing frames to stdout/stderr
+// as they arrive rather than waiting for the response to finish. Unless
+// since is the zero Time, a since=<unix timestamp> query param is added so
+// only output logged at or after since is replayed.
+func (a *apiRuntime) StreamLogs(id string, stdout, stderr io.Writer, since time.Time) error {
+	path := "/containers/" + id + "/logs?follow=1&stdout=1&stderr=1"
+	if !since.IsZero() {
+		path += fmt.Sprintf("&since=%d", since.Unix())
+	}
+	resp, err := a.do("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("docker: logs: %v", decodeOrAPIError(resp, nil))
+	}
+	defer resp.Body.Close()
+	return demuxDockerStream(resp.Body, stdout, stderr)
+}
+
+func (a *apiRuntime) Start(id string) error {
+	resp, err := a.do("POST", "/containers/"+id+"/start", nil)
+	if err != nil {
+		return err
+	}
+	return decodeOrAPIError(resp, nil)
+}
+
+func (a *apiRuntime) Stop(id string, timeout time.Duration) error {
+	path := fmt.Sprintf("/containers/%s/stop?t=%d", id, int(timeout.Seconds()))
+	resp, err := a.do("POST", path, nil)
+	if err != nil {
+		return err
+	}
+	return decodeOrAPIError(resp, nil)
+}
+
+func (a *apiRuntime) Kill(id string) error {
+	resp, err := a.do("POST", "/containers/"+id+"/kill", nil)
+	if err != nil {
+		return err
+	}
+	return decodeOrAPIError(resp, nil)
+}
+
+func (a *apiRuntime) Signal(id, signal string) error {
+	resp, err := a.do("POST", "/containers/"+id+"/kill?signal="+url.QueryEscape(signal), nil)
+	if err != nil {
+		return err
+	}
+	return decodeOrAPIError(resp, nil)
+}
+
+func (a *apiRuntime) Rm(id string) error {
+	resp, err := a.do("DELETE", "/containers/"+id, nil)
+	if err != nil {
+		return err
+	}
+	return decodeOrAPIError(resp, nil)
+}
+
+// demuxDockerStream splits a non-TTY Docker Engine API log/attach stream
+// into its stdout and stderr payloads. Each frame is an 8-byte header
+// [STREAM_TYPE, 0, 0, 0, SIZE(4 bytes big-endian)] followed by SIZE bytes
+// of payload, where STREAM_TYPE is 1 for stdout and 2 for stderr.
+func demuxDockerStream(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		size := binary.BigEndian.Uint32(header[4:8])
+		dst := stdout
+		if header[0] == 2 {
+			dst = stderr
+		}
+		if _, err := io.CopyN(dst, r, int64(size)); err != nil {
+			return err
+		}
+	}
+}
+
+// containerConfig and hostConfig are the minimal subsets of the Docker
+// Engine API's /containers/create request body that dockrun's argument
+// parser below populates. See the Engine API reference for the full schema.
+type containerConfig struct {
+	Image        string              `json:"Image"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	User         string              `json:"User,omitempty"`
+	Hostname     string              `json:"Hostname,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	HostConfig   hostConfig          `json:"HostConfig"`
+}
+
+type hostConfig struct {
+	Binds          []string                 `json:"Binds,omitempty"`
+	PortBindings   map[string][]portBinding `json:"PortBindings,omitempty"`
+	RestartPolicy  restartPolicy            `json:"RestartPolicy"`
+	NetworkMode    string                   `json:"NetworkMode,omitempty"`
+	Privileged     bool                     `json:"Privileged,omitempty"`
+	ReadonlyRootfs bool                     `json:"ReadonlyRootfs,omitempty"`
+	AutoRemove     bool                     `json:"AutoRemove,omitempty"`
+	Init           bool                     `json:"Init,omitempty"`
+}
+
+type portBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+type restartPolicy struct {
+	Name              string `json:"Name,omitempty"`
+	MaximumRetryCount int    `json:"MaximumRetryCount,omitempty"`
+}
+
+// boolRunFlags are the `docker run` flags that take no value, as opposed to
+// the rest of the flags below which all consume the following argument.
+var boolRunFlags = map[string]bool{
+	"--rm":         true,
+	"--privileged": true,
+	"--read-only":  true,
+	"--init":       true,
+}
+
+// parseRunArgs translates the CLI-style arguments dockrun forwards to
+// `docker run` into a containerConfig for POST /containers/create, plus an
+// optional container name. It understands the flags dockrun's users have
+// needed in practice; anything else is rejected so a misconfiguration fails
+// loudly instead of silently reaching the API half-translated.
+func parseRunArgs(args []string) (*containerConfig, string, error) {
+	cfg := &containerConfig{}
+	var name, image string
+
+	i := 0
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			image = arg
+			i++
+			break
+		}
+
+		flag, value, hasValue := splitFlag(arg)
+
+		if boolRunFlags[flag] {
+			switch flag {
+			case "--rm":
+				cfg.HostConfig.AutoRemove = true
+			case "--privileged":
+				cfg.HostConfig.Privileged = true
+			case "--read-only":
+				cfg.HostConfig.ReadonlyRootfs = true
+			case "--init":
+				cfg.HostConfig.Init = true
+			}
+			continue
+		}
+
+		if !hasValue {
+			i++
+			if i >= len(args) {
+				return nil, "", fmt.Errorf("flag %s requires a value", flag)
+			}
+			value = args[i]
+		}
+
+		switch flag {
+		case "-e", "--env":
+			cfg.Env = append(cfg.Env, value)
+		case "-v", "--volume":
+			cfg.HostConfig.Binds = append(cfg.HostConfig.Binds, value)
+		case "-p", "--publish":
+			if err := addPortBinding(cfg, value); err != nil {
+				return nil, "", err
+			}
+		case "--name":
+			name = value
+		case "-w", "--workdir":
+			cfg.WorkingDir = value
+		case "-u", "--user":
+			cfg.User = value
+		case "-h", "--hostname":
+			cfg.Hostname = value
+		case "--entrypoint":
+			cfg.Entrypoint = []string{value}
+		case "-l", "--label":
+			addLabel(cfg, value)
+		case "--network":
+			cfg.HostConfig.NetworkMode = value
+		default:
+			return nil, "", fmt.Errorf("unsupported flag %s", flag)
+		}
+	}
+
+	if image == "" {
+		return nil, "", fmt.Errorf("missing IMAGE argument")
+	}
+	cfg.Image = image
+	cfg.Cmd = args[i:]
+	return cfg, name, nil
+}
+
+func splitFlag(arg string) (flag, value string, hasValue bool) {
+	if idx := strings.Index(arg, "="); idx != -1 && strings.HasPrefix(arg, "--") {
+		return arg[:idx], arg[idx+1:], true
+	}
+	return arg, "", false
+}
+
+func addPortBinding(cfg *containerConfig, value string) error {
+	parts := strings.Split(value, ":")
+	var hostPort, containerPort string
+	switch len(parts) {
+	case 2:
+		hostPort, containerPort = parts[0], parts[1]
+	case 1:
+		hostPort, containerPort = parts[0], parts[0]
+	default:
+		return fmt.Errorf("invalid -p value %q", value)
+	}
+	if !strings.Contains(containerPort, "/") {
+		containerPort += "/tcp"
+	}
+	if cfg.ExposedPorts == nil {
+		cfg.ExposedPorts = map[string]struct{}{}
+	}
+	cfg.ExposedPorts[containerPort] = struct{}{}
+	if cfg.HostConfig.PortBindings == nil {
+		cfg.HostConfig.PortBindings = map[string][]portBinding{}
+	}
+	cfg.HostConfig.PortBindings[containerPort] = append(cfg.HostConfig.PortBindings[containerPort], portBinding{HostPort: hostPort})
+	return nil
+}
+
+// addLabel applies a -l/--label value, which is either "key=value" or a
+// bare "key" (matching `docker run`'s own label parsing).
+func addLabel(cfg *containerConfig, value string) {
+	if cfg.Labels == nil {
+		cfg.Labels = map[string]string{}
+	}
+	key, val := value, ""
+	if idx := strings.Index(value, "="); idx != -1 {
+		key, val = value[:idx], value[idx+1:]
+	}
+	cfg.Labels[key] = val
+}
@@ -3,72 +3,68 @@ package main
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"os/signal"
 	"strconv"
 	"strings"
-	"syscall"
+	"time"
 )
 
-type cmdResult struct {
-	output   string
-	exitCode int
-	err      error
-}
-
-func getExitCode(err error) (int, error) {
-	exitCode := 0
-	if exiterr, ok := err.(*exec.ExitError); ok {
-		if procExit := exiterr.Sys().(syscall.WaitStatus); ok {
-			return procExit.ExitStatus(), nil
-		}
-	}
-	return exitCode, fmt.Errorf("failed to get exit code")
-}
-
-func runCommandWithOutput(cmd *exec.Cmd) (output string, exitCode int, err error) {
-	exitCode = 0
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		var exiterr error
-		if exitCode, exiterr = getExitCode(err); exiterr != nil {
-			// TODO: Fix this so we check the error's text.
-			// we've failed to retrieve exit code, so we set it to 127
-			exitCode = 127
-		}
-	}
-	output = string(out)
-	return
-}
-
-func runCommandWithOutputResult(cmd *exec.Cmd) cmdResult {
-	output, exitCode, err := runCommandWithOutput(cmd)
-	return cmdResult{output, exitCode, err}
-}
-
-func runCommandSendResult(cmd *exec.Cmd, c chan cmdResult) {
-	c <- runCommandWithOutputResult(cmd)
-}
-
-func waitForResult(containerID string, signals chan os.Signal, waitCmd chan cmdResult) cmdResult {
-	var action string
+// stopEscalationGrace is added on top of the stop timeout before dockrun's
+// own escalation timer fires, giving the backend's "stop -t N" call a
+// little room to return on its own first.
+const stopEscalationGrace = 2 * time.Second
+
+// waitForResult blocks until the container exits or a signal asks dockrun
+// to tear it down. Terminal signals (SIGINT, SIGTERM) trigger a graceful
+// stop with the given timeout, escalating to a kill if the container
+// hasn't exited by then or if a second terminal signal arrives first;
+// every other forwarded signal is passed straight through to the
+// container. The returned bool reports whether the exit was caused by one
+// of those manually delivered signals rather than the container's own
+// process, so callers can tell a deliberate stop from one a restart
+// policy should still act on.
+func waitForResult(rt Runtime, containerID string, stopTimeout time.Duration, signals chan os.Signal, waitCmd chan waitResult) (waitResult, bool) {
+	manuallyStopped := false
+	stopping := false
+	var escalate <-chan time.Time
 	for {
 		select {
 		case sig := <-signals:
-			switch sig {
-			case os.Interrupt:
-				action = "stop"
-			case os.Kill:
-				action = "kill"
-			}
 			fmt.Printf("Received signal: %s; cleaning up\n", sig)
-			cmd := exec.Command("docker", action, containerID)
-			out, _, err := runCommandWithOutput(cmd)
-			if err != nil || strings.Contains(out, "Error") {
-				fmt.Printf("stopping container via signal %s failed\n", sig)
+			switch {
+			case isTerminalSignal(sig):
+				manuallyStopped = true
+				if stopping {
+					if err := rt.Kill(containerID); err != nil {
+						fmt.Printf("killing container failed: %v\n", err)
+					}
+					continue
+				}
+				stopping = true
+				go func() {
+					if err := rt.Stop(containerID, stopTimeout); err != nil {
+						fmt.Printf("stopping container failed: %v\n", err)
+					}
+				}()
+				escalate = time.After(stopTimeout + stopEscalationGrace)
+			case sig == os.Kill:
+				manuallyStopped = true
+				if err := rt.Kill(containerID); err != nil {
+					fmt.Printf("killing container failed: %v\n", err)
+				}
+			default:
+				if err := rt.Signal(containerID, signalName(sig)); err != nil {
+					fmt.Printf("forwarding signal %s failed: %v\n", sig, err)
+				}
 			}
-		case waitResult := <-waitCmd:
-			return waitResult
+		case <-escalate:
+			fmt.Printf("container didn't stop within %s; killing\n", stopTimeout)
+			escalate = nil
+			if err := rt.Kill(containerID); err != nil {
+				fmt.Printf("killing container failed: %v\n", err)
+			}
+		case result := <-waitCmd:
+			return result, manuallyStopped
 		}
 	}
 }
@@ -96,79 +92,158 @@ func validateArgs(args []string) {
 	}
 }
 
-// WARNING: 'docker wait', 'docker logs', 'docker rm', 'docker kill' and 'docker stop'
-// exit with status code 0 even if they've failed.
+// waitResult carries the outcome of an asynchronous Runtime.Wait call.
+type waitResult struct {
+	exitCode int
+	err      error
+}
 
-func main() {
-	var containerID string
-	var finalExitCode int
-	defaultArgs := []string{"run", "-d"}
+// defaultStopTimeout matches `docker stop`'s own default grace period.
+const defaultStopTimeout = 10 * time.Second
 
-	args := os.Args[1:]
-	validateArgs(args)
-	finalArgs := append(defaultArgs, args...)
+// dockrunFlags holds the flags dockrun itself consumes, as opposed to the
+// ones forwarded on to the container runtime.
+type dockrunFlags struct {
+	runtime     string
+	noStream    bool
+	restart     restartSpec
+	stopTimeout time.Duration
+}
 
-	runCmd := exec.Command("docker", finalArgs...)
-	if out, exitCode, err := runCommandWithOutput(runCmd); err != nil {
-		fmt.Printf("docker run: %s", out)
-		fmt.Printf("ERROR docker exited with exit code: %d\n", exitCode)
-		os.Exit(1)
-	} else {
-		containerID = strings.Trim(out, "\n")
+// parseDockrunFlags pulls dockrun's own flags (--runtime/--runtime=<name>,
+// --no-stream, --restart, --stop-timeout) out of args, falling back to
+// DOCKRUN_RUNTIME and then "docker" for the runtime, and returns the
+// remaining args to forward to the backend unchanged. --restart and
+// --stop-timeout are consumed here rather than forwarded on, since dockrun
+// itself drives restarts and stop/kill escalation (see runContainer and
+// waitForResult) instead of relying on the backend's own handling.
+func parseDockrunFlags(args []string) (dockrunFlags, []string, error) {
+	flags := dockrunFlags{
+		runtime:     os.Getenv("DOCKRUN_RUNTIME"),
+		stopTimeout: defaultStopTimeout,
 	}
-	if len(containerID) < 4 {
-		fmt.Printf("ERROR: docker container ID is too small, possibly invalid")
-		os.Exit(1)
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--runtime":
+			if i+1 < len(args) {
+				flags.runtime = args[i+1]
+				i++
+				continue
+			}
+		case strings.HasPrefix(arg, "--runtime="):
+			flags.runtime = strings.TrimPrefix(arg, "--runtime=")
+			continue
+		case arg == "--no-stream":
+			flags.noStream = true
+			continue
+		case arg == "--restart":
+			if i+1 >= len(args) {
+				return flags, nil, fmt.Errorf("flag --restart requires a value")
+			}
+			spec, err := parseRestartSpec(args[i+1])
+			if err != nil {
+				return flags, nil, err
+			}
+			flags.restart = spec
+			i++
+			continue
+		case strings.HasPrefix(arg, "--restart="):
+			spec, err := parseRestartSpec(strings.TrimPrefix(arg, "--restart="))
+			if err != nil {
+				return flags, nil, err
+			}
+			flags.restart = spec
+			continue
+		case arg == "--stop-timeout":
+			if i+1 >= len(args) {
+				return flags, nil, fmt.Errorf("flag --stop-timeout requires a value")
+			}
+			timeout, err := parseStopTimeout(args[i+1])
+			if err != nil {
+				return flags, nil, err
+			}
+			flags.stopTimeout = timeout
+			i++
+			continue
+		case strings.HasPrefix(arg, "--stop-timeout="):
+			timeout, err := parseStopTimeout(strings.TrimPrefix(arg, "--stop-timeout="))
+			if err != nil {
+				return flags, nil, err
+			}
+			flags.stopTimeout = timeout
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	if flags.runtime == "" {
+		flags.runtime = "docker"
 	}
+	return flags, rest, nil
+}
 
-	// hack to handle signals & wait for "docker wait" to be finished
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt, os.Kill)
-	waitCmdRes := make(chan cmdResult, 1)
-	waitCmd := exec.Command("docker", "wait", containerID)
-	go runCommandSendResult(waitCmd, waitCmdRes)
-	waitResult := waitForResult(containerID, signals, waitCmdRes)
-
-	waitOutput := waitResult.output
-	waiterr := waitResult.err
-	// try to run 'docker wait' again; this is needed when we receive a
-	// signal and 'docker wait' fails to retrieve the correct exit code
-	// of the container
-	if waiterr != nil {
-		waitCmd := exec.Command("docker", "wait", containerID)
-		waitOutput, _, waiterr = runCommandWithOutput(waitCmd)
+func parseStopTimeout(value string) (time.Duration, error) {
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --stop-timeout %q", value)
 	}
-	// end hack
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func waitAsync(rt Runtime, containerID string, c chan waitResult) {
+	exitCode, err := rt.Wait(containerID)
+	c <- waitResult{exitCode, err}
+}
 
-	if waiterr != nil || strings.Contains(waitOutput, "Error") {
-		// docker wait failed
-		fmt.Printf("ERROR: docker wait: %s %s\n", waitOutput, waiterr)
-		fmt.Printf("ERROR: docker wait failed\n")
+func main() {
+	flags, args, err := parseDockrunFlags(os.Args[1:])
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
 		os.Exit(1)
 	}
-	waitOutput = strings.Trim(waitOutput, "\n")
-	finalExitCode, err := strconv.Atoi(waitOutput)
+	rt, err := newRuntime(flags.runtime)
 	if err != nil {
-		fmt.Println(waitOutput)
-		fmt.Printf("ERROR: failed to convert exit code to int\n")
+		fmt.Printf("ERROR: %s\n", err)
 		os.Exit(1)
 	}
 
-	logsCmd := exec.Command("docker", "logs", containerID)
-	logsOutput, _, logserr := runCommandWithOutput(logsCmd)
-	if logserr != nil || strings.Contains(logsOutput, "No such container") {
-		fmt.Printf("ERROR: docker logs: %s %s\n", logsOutput, logserr)
-		fmt.Printf("ERROR: docker logs failed\n")
-	} else {
-		fmt.Printf(logsOutput)
+	validateArgs(args)
+
+	containerID, err := rt.Run(args)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
 	}
 
-	rmCmd := exec.Command("docker", "rm", containerID)
-	rmOutput, _, rmerr := runCommandWithOutput(rmCmd)
-	if rmerr != nil || strings.Contains(rmOutput, "Error") {
-		fmt.Printf("ERROR: docker rm: %s %s\n", rmOutput, rmerr)
-		fmt.Printf("ERROR: docker rm failed\n")
+	// signal.Notify drops a signal outright if the channel isn't drained in
+	// time, so the buffer needs real headroom: a burst of forwarded,
+	// non-terminal signals (SIGWINCH from a dragged terminal can fire
+	// dozens of events) must not be able to fill it and cause a real
+	// SIGINT/SIGTERM to be silently lost behind them.
+	const signalBufferSize = 64
+	signals := make(chan os.Signal, signalBufferSize)
+	signal.Notify(signals, append(forwardedSignals, os.Kill)...)
+	result := runContainer(rt, containerID, flags.restart, flags.stopTimeout, signals, flags.noStream, os.Stdout, os.Stderr)
+
+	if result.err != nil {
+		fmt.Printf("ERROR: %s wait failed: %s\n", flags.runtime, result.err)
+		os.Exit(1)
+	}
+	finalExitCode := result.exitCode
+
+	if flags.noStream {
+		logsOutput, logserr := rt.Logs(containerID)
+		if logserr != nil {
+			fmt.Printf("ERROR: %s\n", logserr)
+		} else {
+			fmt.Printf(logsOutput)
+		}
+	}
+
+	if rmerr := rt.Rm(containerID); rmerr != nil {
+		fmt.Printf("ERROR: %s\n", rmerr)
 		// fall through and let the return code of the container go through
 	}
 	os.Exit(finalExitCode)
-}
\ No newline at end of file
+}
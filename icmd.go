@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Cmd describes a command for RunCmd to execute.
+type Cmd struct {
+	Command []string
+	Env     []string
+	Stdin   io.Reader
+	Timeout time.Duration
+	Dir     string
+}
+
+// Result is the outcome of running a Cmd: its separated stdout/stderr,
+// a combined interleaving of the two in the order they were written, its
+// exit code, and whether it failed to run at all or timed out.
+type Result struct {
+	Stdout   string
+	Stderr   string
+	Combined string
+	ExitCode int
+	Error    error
+	Timeout  bool
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes, needed because
+// RunCmd copies stdout and stderr into the combined buffer from two
+// goroutines at once.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// RunCmd runs cmd to completion, or until cmd.Timeout elapses, in which
+// case its whole process group is killed. It is the substrate every
+// subsystem that shells out (the CLI-backed Runtime implementations, and
+// anything else that needs a subprocess) is built on.
+func RunCmd(cmd Cmd) Result {
+	if len(cmd.Command) == 0 {
+		return Result{Error: fmt.Errorf("icmd: empty command")}
+	}
+
+	c := exec.Command(cmd.Command[0], cmd.Command[1:]...)
+	c.Env = cmd.Env
+	c.Dir = cmd.Dir
+	c.Stdin = cmd.Stdin
+	setPgid(c)
+
+	var stdout, stderr, combined syncBuffer
+	c.Stdout = io.MultiWriter(&stdout, &combined)
+	c.Stderr = io.MultiWriter(&stderr, &combined)
+
+	if err := c.Start(); err != nil {
+		return Result{Error: err}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.Wait() }()
+
+	var timedOut bool
+	var err error
+	if cmd.Timeout > 0 {
+		select {
+		case err = <-done:
+		case <-time.After(cmd.Timeout):
+			timedOut = true
+			killProcessGroup(c)
+			err = <-done
+		}
+	} else {
+		err = <-done
+	}
+
+	return Result{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Combined: combined.String(),
+		ExitCode: exitCodeOf(c),
+		Error:    runError(err),
+		Timeout:  timedOut,
+	}
+}
+
+// exitCodeOf reads the real exit code off ProcessState, which has
+// reported it portably since Go 1.12 (ExitCode), rather than type-asserting
+// the platform-specific syscall.WaitStatus the old getExitCode did.
+func exitCodeOf(cmd *exec.Cmd) int {
+	if cmd.ProcessState == nil {
+		return -1
+	}
+	return cmd.ProcessState.ExitCode()
+}
+
+// runError drops the *exec.ExitError Cmd.Wait returns for a non-zero exit
+// status: that's already captured in Result.ExitCode, so Result.Error is
+// reserved for failures to run the command at all (not found, killed
+// before exit, etc).
+func runError(err error) error {
+	if _, ok := err.(*exec.ExitError); ok {
+		return nil
+	}
+	return err
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("exit=%d stdout=%q stderr=%q", r.ExitCode, r.Stdout, r.Stderr)
+}
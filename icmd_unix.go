@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setPgid puts cmd in its own process group so killProcessGroup can reap
+// everything it spawned, not just the direct child.
+func setPgid(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills cmd's whole process group, not just the direct
+// child, so a timeout also reaps anything the command itself spawned.
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
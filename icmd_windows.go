@@ -0,0 +1,17 @@
+//go:build windows
+
+package main
+
+import "os/exec"
+
+// setPgid is a no-op on Windows, which has no POSIX process groups.
+func setPgid(cmd *exec.Cmd) {}
+
+// killProcessGroup falls back to killing just the direct child, since
+// Windows has no process-group signal equivalent to killing -pid.
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	cmd.Process.Kill()
+}
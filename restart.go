@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// restartSpec is dockrun's parsed form of the --restart flag: whether to
+// restart a stopped container, and how many times.
+type restartSpec struct {
+	// policy is "no", "always", or "on-failure"; the empty string
+	// behaves like "no".
+	policy     string
+	maxRetries int // 0 means unlimited
+}
+
+// parseRestartSpec parses the value of --restart, e.g. "always" or
+// "on-failure:5", the same syntax `docker run --restart` accepts.
+func parseRestartSpec(value string) (restartSpec, error) {
+	parts := strings.SplitN(value, ":", 2)
+	spec := restartSpec{policy: parts[0]}
+	switch spec.policy {
+	case "no", "always", "on-failure":
+	default:
+		return restartSpec{}, fmt.Errorf("unsupported --restart policy %q", value)
+	}
+	if len(parts) == 2 {
+		n, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return restartSpec{}, fmt.Errorf("invalid --restart retry count %q", value)
+		}
+		spec.maxRetries = n
+	}
+	return spec, nil
+}
+
+// shouldRestart reports whether a container that exited with exitCode
+// should be restarted again, given it has already been restarted attempt
+// times.
+func (s restartSpec) shouldRestart(exitCode, attempt int) bool {
+	if s.maxRetries != 0 && attempt >= s.maxRetries {
+		return false
+	}
+	switch s.policy {
+	case "always":
+		return true
+	case "on-failure":
+		return exitCode != 0
+	default:
+		return false
+	}
+}
+
+// restartBackoff mirrors Docker's own restart manager: it starts at
+// 100ms and doubles on every attempt, capped at one minute.
+func restartBackoff(attempt int) time.Duration {
+	backoff := 100 * time.Millisecond
+	for i := 0; i < attempt && backoff < time.Minute; i++ {
+		backoff *= 2
+	}
+	if backoff > time.Minute {
+		backoff = time.Minute
+	}
+	return backoff
+}
+
+// runContainer waits for containerID to exit and, per spec, restarts it
+// and waits again until the policy is exhausted, a restart fails, or the
+// exit was caused by a manually delivered stop/kill signal (tracked by
+// waitForResult) rather than the process itself. Unless noStream is set, it
+// (re-)attaches a log stream to stdout/stderr for the initial run and again
+// after every restart, since a restarted container gets a fresh log stream
+// rather than resuming the old one. Docker never truncates a container's
+// log file across restarts, so each attach after the first passes the
+// previous attach's timestamp as "since" to avoid re-printing everything
+// that streamed before it.
+func runContainer(rt Runtime, containerID string, spec restartSpec, stopTimeout time.Duration, signals chan os.Signal, noStream bool, stdout, stderr io.Writer) waitResult {
+	attempt := 0
+	var since time.Time
+	for {
+		if !noStream {
+			go rt.StreamLogs(containerID, stdout, stderr, since)
+		}
+		since = time.Now()
+
+		waitCmdRes := make(chan waitResult, 1)
+		go waitAsync(rt, containerID, waitCmdRes)
+
+		result, manuallyStopped := waitForResult(rt, containerID, stopTimeout, signals, waitCmdRes)
+		if manuallyStopped || result.err != nil || !spec.shouldRestart(result.exitCode, attempt) {
+			return result
+		}
+
+		time.Sleep(restartBackoff(attempt))
+		attempt++
+		if err := rt.Start(containerID); err != nil {
+			return waitResult{result.exitCode, fmt.Errorf("restart: %v", err)}
+		}
+	}
+}
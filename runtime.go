@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Runtime is a container runtime backend that dockrun can drive to run a
+// single container, wait for it to exit, fetch its logs, and clean up
+// afterwards. Concrete implementations wrap whichever CLI (or, eventually,
+// API) the backend exposes so the rest of dockrun never shells out
+// directly.
+type Runtime interface {
+	// Run creates and starts a detached container from args, the same
+	// argument list accepted by `docker run` (minus -d, which dockrun
+	// always adds), and returns its ID.
+	Run(args []string) (string, error)
+	// Wait blocks until the container exits and returns its exit code.
+	Wait(id string) (int, error)
+	// Logs returns the container's combined stdout/stderr output.
+	Logs(id string) (string, error)
+	// StreamLogs attaches to the container's log stream and copies
+	// demultiplexed output to stdout/stderr as it's produced, returning
+	// once the stream ends (normally because the container exits). Unless
+	// since is the zero Time, only output logged at or after since is
+	// streamed, so a caller re-attaching after a restart doesn't replay
+	// everything already printed.
+	StreamLogs(id string, stdout, stderr io.Writer, since time.Time) error
+	// Start restarts a container that has already exited, reusing its
+	// existing ID and configuration.
+	Start(id string) error
+	// Stop asks the container to terminate gracefully, giving it timeout
+	// to do so before the backend's own escalation (if any) kicks in.
+	Stop(id string, timeout time.Duration) error
+	// Kill forcibly terminates the container.
+	Kill(id string) error
+	// Signal forwards an arbitrary named signal (e.g. "HUP", "USR1") to
+	// the container's process, the way `docker kill --signal` does.
+	Signal(id, signal string) error
+	// Rm removes a stopped container.
+	Rm(id string) error
+}
+
+// cliRuntime drives any container runtime that speaks the `docker run` /
+// `docker wait` / `docker logs` CLI dialect, which covers docker itself as
+// well as podman and nerdctl.
+type cliRuntime struct {
+	bin string
+}
+
+func (r *cliRuntime) Run(args []string) (string, error) {
+	finalArgs := append([]string{"run", "-d"}, args...)
+	result := RunCmd(Cmd{Command: append([]string{r.bin}, finalArgs...)})
+	if result.Error != nil || result.ExitCode != 0 {
+		return "", fmt.Errorf("%s run: %s (exit %d)", r.bin, result.Combined, result.ExitCode)
+	}
+	id := strings.Trim(result.Stdout, "\n")
+	if len(id) < 4 {
+		return "", fmt.Errorf("%s run: container ID is too small, possibly invalid", r.bin)
+	}
+	return id, nil
+}
+
+func (r *cliRuntime) Wait(id string) (int, error) {
+	result := RunCmd(Cmd{Command: []string{r.bin, "wait", id}})
+	if result.Error != nil || result.ExitCode != 0 || strings.Contains(result.Combined, "Error") {
+		return 0, fmt.Errorf("%s wait: %s", r.bin, result.Combined)
+	}
+	out := strings.Trim(result.Stdout, "\n")
+	exitCode, err := strconv.Atoi(out)
+	if err != nil {
+		return 0, fmt.Errorf("%s wait: failed to parse exit code %q: %v", r.bin, out, err)
+	}
+	return exitCode, nil
+}
+
+func (r *cliRuntime) Logs(id string) (string, error) {
+	result := RunCmd(Cmd{Command: []string{r.bin, "logs", id}})
+	if result.Error != nil || strings.Contains(result.Combined, "No such container") {
+		return result.Combined, fmt.Errorf("%s logs: %s", r.bin, result.Combined)
+	}
+	return result.Combined, nil
+}
+
+// StreamLogs follows the container's log stream via `docker logs -f`,
+// relying on the CLI itself to demultiplex stdout/stderr the way it would
+// for any other foreground command.
+func (r *cliRuntime) StreamLogs(id string, stdout, stderr io.Writer, since time.Time) error {
+	sinceArg := "0"
+	if !since.IsZero() {
+		sinceArg = since.UTC().Format(time.RFC3339Nano)
+	}
+	cmd := exec.Command(r.bin, "logs", "-f", "--since", sinceArg, id)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
+func (r *cliRuntime) Start(id string) error {
+	return r.run("start", id)
+}
+
+func (r *cliRuntime) Stop(id string, timeout time.Duration) error {
+	return r.run("stop", "-t", strconv.Itoa(int(timeout.Seconds())), id)
+}
+
+func (r *cliRuntime) Kill(id string) error {
+	return r.run("kill", id)
+}
+
+func (r *cliRuntime) Signal(id, signal string) error {
+	return r.run("kill", "--signal="+signal, id)
+}
+
+func (r *cliRuntime) Rm(id string) error {
+	return r.run("rm", id)
+}
+
+func (r *cliRuntime) run(args ...string) error {
+	result := RunCmd(Cmd{Command: append([]string{r.bin}, args...)})
+	if result.Error != nil || result.ExitCode != 0 || strings.Contains(result.Combined, "Error") {
+		return fmt.Errorf("%s %s: %s", r.bin, strings.Join(args, " "), result.Combined)
+	}
+	return nil
+}
+
+// runscRuntime drives containers through the docker CLI configured to use
+// the runsc (gVisor) OCI runtime. This is the same approach gVisor's own
+// dockerutil test helper takes: a plain docker invocation with
+// --runtime=runsc inserted ahead of the image and command.
+type runscRuntime struct {
+	cliRuntime
+}
+
+func newRunscRuntime() *runscRuntime {
+	return &runscRuntime{cliRuntime{bin: "docker"}}
+}
+
+func (r *runscRuntime) Run(args []string) (string, error) {
+	return r.cliRuntime.Run(append([]string{"--runtime=runsc"}, args...))
+}
+
+// newRuntime resolves a Runtime backend by name, as selected via the
+// --runtime flag or DOCKRUN_RUNTIME environment variable.
+func newRuntime(name string) (Runtime, error) {
+	switch name {
+	case "docker":
+		return newAPIRuntime()
+	case "podman":
+		return &cliRuntime{bin: "podman"}, nil
+	case "nerdctl":
+		return &cliRuntime{bin: "nerdctl"}, nil
+	case "runsc":
+		return newRunscRuntime(), nil
+	default:
+		return nil, fmt.Errorf("unknown --runtime %q (want docker, podman, nerdctl, or runsc)", name)
+	}
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// isTerminalSignal reports whether sig should stop the container (via the
+// stop/escalate-to-kill path) rather than simply being forwarded to it.
+func isTerminalSignal(sig os.Signal) bool {
+	return sig == os.Interrupt || sig == syscall.SIGTERM
+}
@@ -0,0 +1,41 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// forwardedSignals are the signals dockrun registers for interest in,
+// mirroring the set `docker run` itself forwards to a container's PID 1:
+// SIGINT/SIGTERM trigger the graceful stop-then-escalate path, the rest
+// are passed straight through to the container via Runtime.Signal.
+var forwardedSignals = []os.Signal{
+	os.Interrupt,
+	syscall.SIGTERM,
+	syscall.SIGHUP,
+	syscall.SIGQUIT,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGWINCH,
+}
+
+// signalName maps a forwarded signal to the name `docker kill --signal`
+// expects.
+func signalName(sig os.Signal) string {
+	switch sig {
+	case syscall.SIGHUP:
+		return "HUP"
+	case syscall.SIGQUIT:
+		return "QUIT"
+	case syscall.SIGUSR1:
+		return "USR1"
+	case syscall.SIGUSR2:
+		return "USR2"
+	case syscall.SIGWINCH:
+		return "WINCH"
+	default:
+		return sig.String()
+	}
+}
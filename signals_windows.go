@@ -0,0 +1,24 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// forwardedSignals is the Windows subset of the Unix signal set dockrun
+// forwards: Windows has no equivalent of SIGHUP/SIGQUIT/SIGUSR1/SIGUSR2/
+// SIGWINCH, so only the stop-then-escalate path applies here.
+var forwardedSignals = []os.Signal{
+	os.Interrupt,
+	syscall.SIGTERM,
+}
+
+// signalName maps a forwarded signal to the name `docker kill --signal`
+// expects. Windows never reaches the forwarding branch in waitForResult
+// since both signals it registers are terminal ones, but this keeps
+// Runtime.Signal callable.
+func signalName(sig os.Signal) string {
+	return sig.String()
+}